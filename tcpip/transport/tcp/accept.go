@@ -5,7 +5,6 @@
 package tcp
 
 import (
-	"crypto/rand"
 	"crypto/sha1"
 	"encoding/binary"
 	"hash"
@@ -36,6 +35,30 @@ const (
 	// timestamp and the current timestamp. If the difference is greater
 	// than maxTSDiff, the cookie is expired.
 	maxTSDiff = 2
+
+	// nonceRotationPeriod is how often a listenContext rotates its
+	// SYN-cookie nonces. It is derived from maxTSDiff so that a nonce is
+	// never in the "previous epoch" position for longer than a cookie can
+	// possibly remain valid.
+	nonceRotationPeriod = maxTSDiff << 6 * time.Second
+
+	// currentNonce and previousNonce index into listenContext.nonce.
+	currentNonce  = 1
+	previousNonce = 0
+
+	// mssIndexBits, wscaleBits and the one SACK-permitted bit are packed,
+	// in that order from the low bit, into the "data" field a SYN cookie
+	// carries through createCookie/isCookieValid.
+	mssIndexBits  = 2
+	mssIndexMask  = (1 << mssIndexBits) - 1
+	wscaleBits    = 4
+	wscaleMask    = (1 << wscaleBits) - 1
+	wscaleShift   = mssIndexBits
+	sackShift     = mssIndexBits + wscaleBits
+
+	// noWindowScale is the wscale sentinel value, encoded in wscaleBits,
+	// meaning the peer's SYN did not carry a window scale option.
+	noWindowScale = wscaleMask
 )
 
 var (
@@ -61,6 +84,57 @@ func encodeMSS(mss uint16) uint32 {
 	return 0
 }
 
+// encodeSynOpts packs the encoded MSS index, a clamped window scale (0-14,
+// or noWindowScale if the peer didn't offer one), and whether the peer's SYN
+// was SACK-permitted into the "data" field of a SYN cookie, so that a
+// cookie-accepted connection doesn't have to give up window scaling and SACK
+// the way it used to when only the MSS index was preserved.
+func encodeSynOpts(mss uint16, wscale int, sackPermitted bool) uint32 {
+	v := encodeMSS(mss) & mssIndexMask
+
+	ws := uint32(noWindowScale)
+	if wscale >= 0 {
+		ws = uint32(wscale)
+		if ws > wscaleMask-1 {
+			ws = wscaleMask - 1
+		}
+	}
+	v |= ws << wscaleShift
+
+	if sackPermitted {
+		v |= 1 << sackShift
+	}
+
+	return v
+}
+
+// wndScaleForBuffer returns the window scale we should apply to a receive
+// buffer of the given size, clamped to the 0-14 range a single wscale option
+// byte can carry. It depends only on our own buffer size: the scale we
+// advertise and apply to our receive window must never be derived from the
+// peer's wscale, which reflects the peer's buffer, not ours.
+func wndScaleForBuffer(rcvWnd seqnum.Size) int {
+	ws := 0
+	for rcvWnd>>uint(ws) > 0xffff && ws < wscaleMask-1 {
+		ws++
+	}
+	return ws
+}
+
+// decodeSynOpts is the inverse of encodeSynOpts.
+func decodeSynOpts(data uint32) (mssIndex int, wscale int, sackPermitted bool) {
+	mssIndex = int(data & mssIndexMask)
+
+	wscale = -1
+	if ws := int((data >> wscaleShift) & wscaleMask); ws != noWindowScale {
+		wscale = ws
+	}
+
+	sackPermitted = (data>>sackShift)&1 != 0
+
+	return mssIndex, wscale, sackPermitted
+}
+
 // syncRcvdCount is the number of endpoints in the SYN-RCVD state. The value is
 // protected by a mutex so that we can increment only when it's guaranteed not
 // to go above a threshold.
@@ -73,15 +147,104 @@ var synRcvdCount struct {
 // listening for connections. This struct is allocated by the listen goroutine
 // and must not be accessed or have its methods called concurrently as they
 // may mutate the stored objects.
+//
+// It implements Saver (see Save/Load below), though only for the listener
+// metadata scope documented on Saver itself.
+var _ Saver = (*listenContext)(nil)
+
 type listenContext struct {
 	stack  *stack.Stack
 	rcvWnd seqnum.Size
-	nonce  [2][sha1.BlockSize]byte
+
+	// nonceMu protects nonce. nonce[1] is the current epoch's nonce,
+	// nonce[0] the previous epoch's; see rotateNonces.
+	nonceMu sync.RWMutex
+	nonce   [2][sha1.BlockSize]byte
+
+	// stop, closed by protocolListenLoop when it returns, ends the
+	// rotateNonces goroutine.
+	stop chan struct{}
 
 	hasherMu sync.Mutex
 	hasher   hash.Hash
 	v6only   bool
 	netProto tcpip.NetworkProtocolNumber
+
+	// pendingMu protects pendingSegs.
+	pendingMu sync.Mutex
+
+	// pendingSegs holds the segments currently being processed by a
+	// handleSynSegment goroutine, i.e. those in the middle of the SYN-RCVD
+	// handshake. It lets Save enumerate in-flight connections that have
+	// not yet been delivered to the listener's accept queue.
+	pendingSegs map[*segment]struct{}
+
+	// restoredPendingPorts is populated by Load from the port pairs Save
+	// wrote for pendingSegs. It is as far as Load can go on its own: the
+	// segments themselves, and the routes and sequence state needed to
+	// resume their handshakes, live in segment.go/endpoint.go and are not
+	// reconstructed here. The caller is responsible for deciding what to
+	// do with these in-flight connections, e.g. letting them time out and
+	// be retransmitted by the peer.
+	restoredPendingPorts []restoredPendingPort
+
+	// statsMu protects stats.
+	statsMu sync.Mutex
+	stats   ListenStats
+}
+
+// ListenStats holds counters for segment-handling failures seen by a
+// listening endpoint. These represent errors, as distinct from the
+// load-shedding counters on acceptQueue.
+type ListenStats struct {
+	// HandshakeFailed counts SYNs or cookie-bearing ACKs that made it past
+	// option parsing and (where applicable) cookie validation, but then
+	// failed to become a registered endpoint, e.g. because the 4-tuple was
+	// already in use or the route could not be cloned.
+	HandshakeFailed uint64
+
+	// CookieMSSOutOfRange counts ACKs carrying a cookie that validated
+	// (the hash matched and the timestamp was within maxTSDiff) but whose
+	// encoded MSS index fell outside mssTable. This is counted separately
+	// from a cookie that fails validation outright. While mssIndexBits
+	// leaves room for exactly len(mssTable) values, decodeSynOpts never
+	// yields such an index, so this counter stays zero.
+	CookieMSSOutOfRange uint64
+
+	// SegmentHandlingErrors counts segments for which handleListenSegment
+	// returned a non-nil error to protocolListenLoop. It overlaps with
+	// HandshakeFailed for the synchronous (cookie-ACK) path, which is the
+	// only path whose error actually reaches protocolListenLoop; errors
+	// from the handleSynSegment goroutine path are necessarily discarded
+	// by the `go` statement that starts it, and HandshakeFailed alone
+	// accounts for those.
+	SegmentHandlingErrors uint64
+}
+
+// restoredPendingPort identifies a segment that was mid-handshake at the
+// time of a checkpoint, as far as Load is able to report it: the local and
+// remote ports, the only part of a segment's identity Save writes out.
+type restoredPendingPort struct {
+	LocalPort  uint16
+	RemotePort uint16
+}
+
+func (l *listenContext) incHandshakeFailed() {
+	l.statsMu.Lock()
+	l.stats.HandshakeFailed++
+	l.statsMu.Unlock()
+}
+
+func (l *listenContext) incCookieMSSOutOfRange() {
+	l.statsMu.Lock()
+	l.stats.CookieMSSOutOfRange++
+	l.statsMu.Unlock()
+}
+
+func (l *listenContext) incSegmentHandlingError() {
+	l.statsMu.Lock()
+	l.stats.SegmentHandlingErrors++
+	l.statsMu.Unlock()
 }
 
 // timeStamp returns an 8-bit timestamp with a granularity of 64 seconds.
@@ -118,21 +281,157 @@ func decSynRcvdCount() {
 // newListenContext creates a new listen context.
 func newListenContext(stack *stack.Stack, rcvWnd seqnum.Size, v6only bool, netProto tcpip.NetworkProtocolNumber) *listenContext {
 	l := &listenContext{
-		stack:    stack,
-		rcvWnd:   rcvWnd,
-		hasher:   sha1.New(),
-		v6only:   v6only,
-		netProto: netProto,
+		stack:       stack,
+		rcvWnd:      rcvWnd,
+		stop:        make(chan struct{}),
+		hasher:      sha1.New(),
+		v6only:      v6only,
+		netProto:    netProto,
+		pendingSegs: make(map[*segment]struct{}),
 	}
 
-	rand.Read(l.nonce[0][:])
-	rand.Read(l.nonce[1][:])
+	secureRNG.Read(l.nonce[0][:])
+	secureRNG.Read(l.nonce[1][:])
+
+	go l.rotateNonces()
 
 	return l
 }
 
+// rotateNonces runs for the lifetime of protocolListenLoop, promoting the
+// current epoch's nonce to the previous epoch and generating a fresh current
+// nonce every nonceRotationPeriod. This bounds how long a leaked or
+// brute-forced nonce remains useful, while isCookieValid's two-epoch check
+// keeps cookies issued just before a rotation valid.
+func (l *listenContext) rotateNonces() {
+	t := time.NewTicker(nonceRotationPeriod)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-t.C:
+			l.nonceMu.Lock()
+			l.nonce[0] = l.nonce[1]
+			secureRNG.Read(l.nonce[1][:])
+			l.nonceMu.Unlock()
+		}
+	}
+}
+
+// addPending marks s as undergoing a SYN-RCVD handshake in a
+// handleSynSegment goroutine.
+func (l *listenContext) addPending(s *segment) {
+	l.pendingMu.Lock()
+	l.pendingSegs[s] = struct{}{}
+	l.pendingMu.Unlock()
+}
+
+// removePending undoes a previous call to addPending.
+func (l *listenContext) removePending(s *segment) {
+	l.pendingMu.Lock()
+	delete(l.pendingSegs, s)
+	l.pendingMu.Unlock()
+}
+
+// Save implements Saver. It writes the subset of listener state that lives
+// in listenContext to w, so that it can be reconstructed by a later call to
+// Load in a restored process. This covers rcvWnd, v6only, netProto, the two
+// SYN-cookie nonces, and the port pairs of segments mid-handshake; per
+// Saver's doc comment, it does not persist endpoint, sender, receiver or
+// segment state, which live outside this file and are out of scope here.
+func (l *listenContext) Save(w io.Writer) error {
+	l.pendingMu.Lock()
+	defer l.pendingMu.Unlock()
+
+	l.nonceMu.RLock()
+	nonce0, nonce1 := l.nonce[0], l.nonce[1]
+	l.nonceMu.RUnlock()
+
+	for _, v := range []interface{}{
+		l.rcvWnd,
+		l.v6only,
+		uint32(l.netProto),
+		nonce0,
+		nonce1,
+		uint32(len(l.pendingSegs)),
+	} {
+		if err := binary.Write(w, binary.BigEndian, v); err != nil {
+			return err
+		}
+	}
+
+	for s := range l.pendingSegs {
+		if err := binary.Write(w, binary.BigEndian, s.id.LocalPort); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, s.id.RemotePort); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Load implements Saver. It is the inverse of Save. The caller is
+// responsible for re-registering the listening endpoint and re-launching
+// protocolListenLoop; Load only restores the listenContext fields
+// themselves. Pending segment identities are read back into
+// restoredPendingPorts rather than turned into resumed handshakes, since
+// that requires re-creating segment and endpoint values from
+// segment.go/endpoint.go; see restoredPendingPort.
+func (l *listenContext) Load(r io.Reader) error {
+	for _, v := range []interface{}{
+		&l.rcvWnd,
+		&l.v6only,
+	} {
+		if err := binary.Read(r, binary.BigEndian, v); err != nil {
+			return err
+		}
+	}
+
+	var netProto uint32
+	if err := binary.Read(r, binary.BigEndian, &netProto); err != nil {
+		return err
+	}
+	l.netProto = tcpip.NetworkProtocolNumber(netProto)
+
+	l.nonceMu.Lock()
+	for i := range l.nonce {
+		if err := binary.Read(r, binary.BigEndian, &l.nonce[i]); err != nil {
+			l.nonceMu.Unlock()
+			return err
+		}
+	}
+	l.nonceMu.Unlock()
+
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return err
+	}
+	l.pendingSegs = make(map[*segment]struct{}, n)
+	l.restoredPendingPorts = make([]restoredPendingPort, 0, n)
+	for i := uint32(0); i < n; i++ {
+		var localPort, remotePort uint16
+		if err := binary.Read(r, binary.BigEndian, &localPort); err != nil {
+			return err
+		}
+		if err := binary.Read(r, binary.BigEndian, &remotePort); err != nil {
+			return err
+		}
+		l.restoredPendingPorts = append(l.restoredPendingPorts, restoredPendingPort{
+			LocalPort:  localPort,
+			RemotePort: remotePort,
+		})
+	}
+
+	return nil
+}
+
 // cookieHash calculates the cookieHash for the given id, timestamp and nonce
-// index. The hash is used to create and validate cookies.
+// index. The hash is used to create and validate cookies. nonceIndex selects
+// which epoch's nonce to hash with; see currentNonce/previousNonce.
 func (l *listenContext) cookieHash(id stack.TransportEndpointID, ts uint32, nonceIndex int) uint32 {
 
 	// Initialize block with fixed-size data: local ports and v.
@@ -141,11 +440,15 @@ func (l *listenContext) cookieHash(id stack.TransportEndpointID, ts uint32, nonc
 	binary.BigEndian.PutUint16(payload[2:], id.RemotePort)
 	binary.BigEndian.PutUint32(payload[4:], ts)
 
+	l.nonceMu.RLock()
+	nonce := l.nonce[nonceIndex]
+	l.nonceMu.RUnlock()
+
 	// Feed everything to the hasher.
 	l.hasherMu.Lock()
 	l.hasher.Reset()
 	l.hasher.Write(payload[:])
-	l.hasher.Write(l.nonce[nonceIndex][:])
+	l.hasher.Write(nonce[:])
 	io.WriteString(l.hasher, string(id.LocalAddress))
 	io.WriteString(l.hasher, string(id.RemoteAddress))
 
@@ -158,31 +461,38 @@ func (l *listenContext) cookieHash(id stack.TransportEndpointID, ts uint32, nonc
 }
 
 // createCookie creates a SYN cookie for the given id and incoming sequence
-// number.
+// number. It always uses the current epoch's nonce, since a freshly-created
+// cookie starts life in the current epoch.
 func (l *listenContext) createCookie(id stack.TransportEndpointID, seq seqnum.Value, data uint32) seqnum.Value {
 	ts := timeStamp()
-	v := l.cookieHash(id, 0, 0) + uint32(seq) + (ts << tsOffset)
-	v += (l.cookieHash(id, ts, 1) + data) & hashMask
+	v := l.cookieHash(id, 0, currentNonce) + uint32(seq) + (ts << tsOffset)
+	v += (l.cookieHash(id, ts, currentNonce) + data) & hashMask
 	return seqnum.Value(v)
 }
 
 // isCookieValid checks if the supplied cookie is valid for the given id and
-// sequence number. If it is, it also returns the data originally encoded in the
-// cookie when createCookie was called.
+// sequence number. If it is, it also returns the data originally encoded in
+// the cookie when createCookie was called. It tries both the current and the
+// previous epoch's nonce, so a cookie issued just before a nonce rotation
+// still authenticates.
 func (l *listenContext) isCookieValid(id stack.TransportEndpointID, cookie seqnum.Value, seq seqnum.Value) (uint32, bool) {
 	ts := timeStamp()
-	v := uint32(cookie) - l.cookieHash(id, 0, 0) - uint32(seq)
-	cookieTS := v >> tsOffset
-	if ((ts - cookieTS) & tsMask) > maxTSDiff {
-		return 0, false
+	for _, nonceIndex := range [...]int{currentNonce, previousNonce} {
+		v := uint32(cookie) - l.cookieHash(id, 0, nonceIndex) - uint32(seq)
+		cookieTS := v >> tsOffset
+		if ((ts - cookieTS) & tsMask) > maxTSDiff {
+			continue
+		}
+
+		return (v - l.cookieHash(id, cookieTS, nonceIndex)) & hashMask, true
 	}
 
-	return (v - l.cookieHash(id, cookieTS, 1)) & hashMask, true
+	return 0, false
 }
 
 // createConnectedEndpoint creates a new connected endpoint, with the connection
 // parameters given by the arguments.
-func (l *listenContext) createConnectedEndpoint(s *segment, iss seqnum.Value, irs seqnum.Value, mss uint16, sndWndScale int) (*endpoint, error) {
+func (l *listenContext) createConnectedEndpoint(s *segment, iss seqnum.Value, irs seqnum.Value, mss uint16, sndWndScale int, sackPermitted bool) (*endpoint, *tcpip.Error) {
 	// Create a new endpoint.
 	netProto := l.netProto
 	if netProto == 0 {
@@ -195,6 +505,7 @@ func (l *listenContext) createConnectedEndpoint(s *segment, iss seqnum.Value, ir
 	n.route = s.route.Clone()
 	n.effectiveNetProtos = []tcpip.NetworkProtocolNumber{s.route.NetProto}
 	n.rcvBufSize = int(l.rcvWnd)
+	n.sackPermitted = sackPermitted
 
 	// Register new endpoint so that packets are routed to it.
 	if err := n.stack.RegisterTransportEndpoint(n.boundNICID, n.effectiveNetProtos, ProtocolNumber, n.id, n); err != nil {
@@ -207,21 +518,28 @@ func (l *listenContext) createConnectedEndpoint(s *segment, iss seqnum.Value, ir
 
 	// Create sender and receiver.
 	//
-	// The receiver at least temporarily has a zero receive window scale,
-	// but the caller may change it (before starting the protocol loop).
+	// sndWndScale is the peer's wscale, needed so the sender interprets
+	// the peer's advertised window correctly; it says nothing about the
+	// scale we apply to our own receive window; see wndScaleForBuffer.
+	// A sndWndScale of -1 means the peer's SYN carried no wscale option
+	// at all, in which case neither side scales its window.
+	ourRcvWndScale := 0
+	if sndWndScale >= 0 {
+		ourRcvWndScale = wndScaleForBuffer(l.rcvWnd)
+	}
 	n.snd = newSender(n, iss, s.window, mss, sndWndScale)
-	n.rcv = newReceiver(n, irs, l.rcvWnd, 0)
+	n.rcv = newReceiver(n, irs, l.rcvWnd, ourRcvWndScale)
 
 	return n, nil
 }
 
 // createEndpoint creates a new endpoint in connected state and then performs
 // the TCP 3-way handshake.
-func (l *listenContext) createEndpointAndPerformHandshake(s *segment, mss uint16, sndWndScale int) (*endpoint, error) {
+func (l *listenContext) createEndpointAndPerformHandshake(s *segment, mss uint16, sndWndScale int, sackPermitted bool) (*endpoint, *tcpip.Error) {
 	// Create new endpoint.
 	irs := s.sequenceNumber
-	cookie := l.createCookie(s.id, irs, encodeMSS(mss))
-	ep, err := l.createConnectedEndpoint(s, cookie, irs, mss, sndWndScale)
+	cookie := l.createCookie(s.id, irs, encodeSynOpts(mss, sndWndScale, sackPermitted))
+	ep, err := l.createConnectedEndpoint(s, cookie, irs, mss, sndWndScale, sackPermitted)
 	if err != nil {
 		return nil, err
 	}
@@ -247,18 +565,21 @@ func (l *listenContext) createEndpointAndPerformHandshake(s *segment, mss uint16
 	return ep, nil
 }
 
-// deliverAccepted delivers the newly-accepted endpoint to the listener. If the
-// endpoint has transitioned out of the listen state, the new endpoint is
-// closed instead.
+// deliverAccepted delivers the newly-accepted endpoint to the listener's
+// acceptQueue. The queue itself is responsible for dropping the endpoint if
+// it is full (counting the drop) or if the listener has already closed;
+// deliverAccepted no longer needs to consult e.state; see
+// acceptQueue.closeAndDrain for why the listener-close race no longer
+// results in deliverAccepted closing endpoints itself.
+//
+// Notify is called after acceptQueue.deliver has already released
+// acceptQueue.mu, and without e.mu held: a waiter's callback may synchronously
+// call back into the endpoint (e.g. Readiness), and calling Notify under
+// either lock would deadlock against that. Any new call site added here or
+// in endpoint.go's corresponding cleanup path must preserve that ordering.
 func (e *endpoint) deliverAccepted(n *endpoint) {
-	e.mu.RLock()
-	if e.state == stateListen {
-		e.acceptedChan <- n
-		e.waiterQueue.Notify(waiter.EventIn)
-	} else {
-		n.Close()
-	}
-	e.mu.RUnlock()
+	e.acceptQueue.deliver(n)
+	e.waiterQueue.Notify(waiter.EventIn)
 }
 
 // handleSynSegment is called in its own goroutine once the listening
@@ -267,47 +588,106 @@ func (e *endpoint) deliverAccepted(n *endpoint) {
 //
 // A limited number of these goroutines are allowed before TCP starts using
 // SYN cookies to accept connections.
-func (e *endpoint) handleSynSegment(ctx *listenContext, s *segment, mss uint16, sndWndScale int) {
+func (e *endpoint) handleSynSegment(ctx *listenContext, s *segment, mss uint16, sndWndScale int, sackPermitted bool) *tcpip.Error {
 	defer decSynRcvdCount()
 	defer s.decRef()
 
-	n, err := ctx.createEndpointAndPerformHandshake(s, mss, sndWndScale)
+	ctx.addPending(s)
+	defer ctx.removePending(s)
+
+	n, err := ctx.createEndpointAndPerformHandshake(s, mss, sndWndScale, sackPermitted)
+
+	// Release this handshake's pending slot before delivering, so that
+	// deliver's isFull check does not count the slot n already holds
+	// against n itself.
+	e.acceptQueue.decPending()
+
 	if err != nil {
-		return
+		ctx.incHandshakeFailed()
+		sendTCP(&s.route, s.id, flagRst|flagAck, 0, s.sequenceNumber+1, 0, -1)
+		return err
 	}
 
 	e.deliverAccepted(n)
+	return nil
 }
 
 // handleListenSegment is called when a listening endpoint receives a segment
 // and needs to handle it.
-func (e *endpoint) handleListenSegment(ctx *listenContext, s *segment) {
+func (e *endpoint) handleListenSegment(ctx *listenContext, s *segment) *tcpip.Error {
 	switch s.flags {
 	case flagSyn:
-		mss, sws, ok := parseSynOptions(s)
+		mss, sws, wscale, sackPermitted, ok := parseSynOptions(s)
 		if !ok {
-			return
+			return nil
+		}
+
+		e.acceptQueue.mu.Lock()
+		full := e.acceptQueue.isFull()
+		e.acceptQueue.mu.Unlock()
+		if full {
+			e.acceptQueue.mu.Lock()
+			e.acceptQueue.synRcvdDrops++
+			e.acceptQueue.mu.Unlock()
+			return nil
 		}
+
 		if incSynRcvdCount() {
 			s.incRef()
-			go e.handleSynSegment(ctx, s, mss, sws)
+			e.acceptQueue.incPending()
+			go e.handleSynSegment(ctx, s, mss, sws, sackPermitted)
 		} else {
-			cookie := ctx.createCookie(s.id, s.sequenceNumber, encodeMSS(mss))
-			// Send SYN with window scaling disabled because we
-			// currently can't encode this information in the
-			// cookie.
-			sendSynTCP(&s.route, s.id, flagSyn|flagAck, cookie, s.sequenceNumber+1, ctx.rcvWnd, -1)
+			data := encodeSynOpts(mss, wscale, sackPermitted)
+			cookie := ctx.createCookie(s.id, s.sequenceNumber, data)
+
+			// The scale we advertise in our SYN-ACK is ours, derived
+			// from ctx.rcvWnd, not the peer's wscale: reusing the
+			// peer's own value here would tell them nothing about our
+			// receive buffer. We still omit it entirely if the peer's
+			// SYN carried no wscale option, per RFC 7323.
+			ourWscale := -1
+			if wscale >= 0 {
+				ourWscale = wndScaleForBuffer(ctx.rcvWnd)
+			}
+			sendSynTCP(&s.route, s.id, flagSyn|flagAck, cookie, s.sequenceNumber+1, ctx.rcvWnd, ourWscale)
+			e.acceptQueue.mu.Lock()
+			e.acceptQueue.cookiesSent++
+			e.acceptQueue.mu.Unlock()
 		}
 
 	case flagAck:
-		if data, ok := ctx.isCookieValid(s.id, s.ackNumber-1, s.sequenceNumber-1); ok && int(data) < len(mssTable) {
-			// Create newly accepted endpoint and deliver it.
-			n, err := ctx.createConnectedEndpoint(s, s.ackNumber-1, s.sequenceNumber-1, mssTable[data], -1)
-			if err == nil {
-				e.deliverAccepted(n)
-			}
+		data, ok := ctx.isCookieValid(s.id, s.ackNumber-1, s.sequenceNumber-1)
+		if !ok {
+			e.acceptQueue.mu.Lock()
+			e.acceptQueue.cookiesRejected++
+			e.acceptQueue.mu.Unlock()
+			return nil
+		}
+
+		mssIndex, wscale, sackPermitted := decodeSynOpts(data)
+		if mssIndex >= len(mssTable) {
+			// The cookie hash and timestamp validated, but the MSS
+			// index it encodes is nonsensical; count this apart
+			// from a flatly invalid cookie since it suggests a
+			// stale binary or corrupted state rather than a forged
+			// or expired one.
+			ctx.incCookieMSSOutOfRange()
+			e.acceptQueue.mu.Lock()
+			e.acceptQueue.cookiesRejected++
+			e.acceptQueue.mu.Unlock()
+			return nil
+		}
+
+		// Create newly accepted endpoint and deliver it.
+		n, err := ctx.createConnectedEndpoint(s, s.ackNumber-1, s.sequenceNumber-1, mssTable[mssIndex], wscale, sackPermitted)
+		if err != nil {
+			ctx.incHandshakeFailed()
+			sendTCP(&s.route, s.id, flagRst|flagAck, s.ackNumber, s.sequenceNumber+1, 0, -1)
+			return err
 		}
+		e.deliverAccepted(n)
 	}
+	return nil
 }
 
 // protocolListenLoop is the main loop of a listening TCP endpoint. It runs in
@@ -319,9 +699,19 @@ func (e *endpoint) protocolListenLoop(rcvWnd seqnum.Size) error {
 		// to the endpoint.
 		e.mu.Lock()
 		e.state = stateClosed
+		e.listenCtx = nil
 		e.mu.Unlock()
 
-		// Notify waiters that the endpoint is shutdown.
+		// Drain and close every endpoint still waiting in the accept
+		// queue: with the listener gone, Accept will never be called
+		// for them again. This also wakes any goroutine blocked in
+		// Accept's cond.Wait, since the queue is now marked closed.
+		e.acceptQueue.closeAndDrain()
+
+		// Notify waiters that the endpoint is shutdown. e.mu is already
+		// released above: a waiter's callback may call back into this
+		// endpoint (e.g. a Readiness check), and doing so while e.mu is
+		// still held would deadlock.
 		e.waiterQueue.Notify(waiter.EventIn | waiter.EventOut)
 
 		// Do cleanup if needed.
@@ -333,11 +723,21 @@ func (e *endpoint) protocolListenLoop(rcvWnd seqnum.Size) error {
 	e.mu.Unlock()
 
 	ctx := newListenContext(e.stack, rcvWnd, v6only, e.netProto)
+	defer close(ctx.stop)
+
+	// Stored so ListenStats (listen.go) has somewhere to read from; see
+	// its doc comment for why this, and not the acceptQueue counters,
+	// needs its own field on endpoint.
+	e.mu.Lock()
+	e.listenCtx = ctx
+	e.mu.Unlock()
 
 	for {
 		select {
 		case s := <-e.segmentChan:
-			e.handleListenSegment(ctx, s)
+			if err := e.handleListenSegment(ctx, s); err != nil {
+				ctx.incSegmentHandlingError()
+			}
 			s.decRef()
 
 		case <-e.notifyChan:
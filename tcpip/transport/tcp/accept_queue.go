@@ -0,0 +1,133 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tcp
+
+import (
+	"container/list"
+	"sync"
+)
+
+// acceptQueue holds the endpoints owned by a single listening endpoint that
+// are either mid-handshake (SYN-RCVD, counted in pendingCount) or have
+// completed the handshake and are waiting to be returned by Accept (held in
+// readyList). It replaces the old fixed-size acceptedChan: the backlog is
+// enforced here, once, against pendingCount+readyList.Len(), rather than
+// being baked into a channel's capacity.
+//
+// It also carries the stats counters surfaced via
+// tcpip.TransportEndpointStats so drops and SYN-cookie activity are
+// observable instead of silent.
+type acceptQueue struct {
+	mu   sync.Mutex
+	cond sync.Cond
+
+	// readyList holds endpoints that have completed the handshake and are
+	// waiting to be delivered by Accept. Elements are *endpoint.
+	readyList list.List
+
+	// pendingCount is the number of endpoints currently performing the
+	// handshake in a handleSynSegment goroutine (SYN-RCVD). It counts
+	// against backlog alongside readyList. Connections answered with a SYN
+	// cookie are not counted here while their cookie ACK is outstanding.
+	pendingCount int
+
+	// backlog is the maximum number of endpoints (ready + pending) the
+	// queue admits. It is fixed once, at Listen time.
+	backlog int
+
+	// closed is set exactly once, by the listener's close path, which is
+	// then responsible for draining and closing every endpoint still in
+	// readyList. Once closed, deliverAccepted no longer queues.
+	closed bool
+
+	// synRcvdDrops counts SYNs rejected outright because the queue (ready
+	// + pending) was already at backlog.
+	synRcvdDrops uint64
+
+	// acceptQueueDrops counts handshake-completed endpoints that could not
+	// be queued because the queue filled up while the handshake was in
+	// progress.
+	acceptQueueDrops uint64
+
+	// cookiesSent counts SYN-ACKs sent carrying a SYN cookie instead of
+	// spawning a SYN-RCVD goroutine.
+	cookiesSent uint64
+
+	// cookiesRejected counts ACKs that failed to validate against any
+	// outstanding cookie, and ACKs whose cookie validated but encoded an
+	// MSS index outside mssTable.
+	cookiesRejected uint64
+}
+
+// newAcceptQueue creates an acceptQueue with the given backlog.
+func newAcceptQueue(backlog int) *acceptQueue {
+	q := &acceptQueue{backlog: backlog}
+	q.cond.L = &q.mu
+	return q
+}
+
+// isFull reports whether the queue has no room for another endpoint,
+// pending or ready. The caller must hold q.mu.
+func (q *acceptQueue) isFull() bool {
+	return q.pendingCount+q.readyList.Len() >= q.backlog
+}
+
+// incPending records the start of a new SYN-RCVD handshake.
+func (q *acceptQueue) incPending() {
+	q.mu.Lock()
+	q.pendingCount++
+	q.mu.Unlock()
+}
+
+// decPending undoes a previous call to incPending.
+func (q *acceptQueue) decPending() {
+	q.mu.Lock()
+	q.pendingCount--
+	q.mu.Unlock()
+}
+
+// deliver queues a handshake-completed endpoint for Accept, or closes it if
+// the queue has no room or has already been closed. Only the no-room case is
+// recorded as a drop, in acceptQueueDrops.
+func (q *acceptQueue) deliver(n *endpoint) {
+	q.mu.Lock()
+	switch {
+	case q.closed:
+		q.mu.Unlock()
+		n.Close()
+		return
+	case q.isFull():
+		q.acceptQueueDrops++
+		q.mu.Unlock()
+		n.Close()
+		return
+	}
+
+	q.readyList.PushBack(n)
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+// closeAndDrain marks the queue closed and closes every endpoint still
+// waiting in readyList exactly once. It is safe to call at most once, from
+// the listener's close path (protocolListenLoop's deferred cleanup). It
+// broadcasts cond so that any goroutine blocked in Accept's cond.Wait wakes
+// up and observes q.closed instead of waiting forever on a queue that will
+// never receive another deliver().
+func (q *acceptQueue) closeAndDrain() {
+	q.mu.Lock()
+	q.closed = true
+	var toClose []*endpoint
+	for e := q.readyList.Front(); e != nil; e = e.Next() {
+		toClose = append(toClose, e.Value.(*endpoint))
+	}
+	q.readyList.Init()
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	for _, n := range toClose {
+		n.Close()
+	}
+}
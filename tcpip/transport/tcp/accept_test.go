@@ -0,0 +1,57 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tcp
+
+import (
+	"testing"
+	"time"
+)
+
+// TestDeliverAcceptedReentrantNotify simulates a waiter that re-enters the
+// endpoint in reaction to a delivery: the goroutine that Accept wakes for the
+// first endpoint makes a second deliverAccepted call, and the test verifies
+// Accept still completes for both endpoints. This checks that
+// acceptQueue.deliver releases its lock before signalling, so a re-entrant
+// deliverAccepted followed by Accept does not deadlock. No waiter entry is
+// registered on e.waiterQueue, so the Notify calls run no callback and the
+// test does not check which locks are held while Notify runs.
+func TestDeliverAcceptedReentrantNotify(t *testing.T) {
+	e := &endpoint{acceptQueue: newAcceptQueue(2)}
+
+	first := &endpoint{}
+	second := &endpoint{}
+
+	accepted := make(chan *endpoint, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			n, err := e.Accept()
+			if err != nil {
+				t.Errorf("Accept: %v", err)
+				return
+			}
+			accepted <- n
+
+			// Simulate a waiter whose callback re-enters the
+			// endpoint as a direct reaction to being notified of
+			// the first delivery.
+			if i == 0 {
+				e.deliverAccepted(second)
+			}
+		}
+	}()
+
+	e.deliverAccepted(first)
+
+	for i, want := range []*endpoint{first, second} {
+		select {
+		case got := <-accepted:
+			if got != want {
+				t.Errorf("accepted[%d] = %p, want %p", i, got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Accept never returned endpoint %d; deliverAccepted may be holding a lock that Accept needs", i)
+		}
+	}
+}
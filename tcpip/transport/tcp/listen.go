@@ -0,0 +1,107 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tcp
+
+import (
+	"github.com/google/netstack/tcpip"
+	"github.com/google/netstack/tcpip/seqnum"
+)
+
+// maxListenBacklog caps the backlog a caller can request of Listen. It used
+// to be enforced implicitly by the capacity of the old accepted channel;
+// acceptQueue has no such built-in cap, so it is checked once, here, instead.
+const maxListenBacklog = 1024
+
+// Listen puts the endpoint in listening state, which allows it to accept new
+// connections via Accept. backlog is the maximum number of endpoints (ready
+// or mid-handshake) the listener will hold before handleListenSegment starts
+// dropping new SYNs; it is clamped to maxListenBacklog.
+func (e *endpoint) Listen(backlog int) *tcpip.Error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.acceptQueue != nil {
+		return tcpip.ErrAlreadyConnected
+	}
+
+	if backlog > maxListenBacklog {
+		backlog = maxListenBacklog
+	}
+
+	e.acceptQueue = newAcceptQueue(backlog)
+	e.state = stateListen
+
+	go e.protocolListenLoop(seqnum.Size(e.rcvBufSize))
+
+	return nil
+}
+
+// Accept returns the next completed connection waiting in the accept queue,
+// blocking until one is available or the listener is closed. It is the only
+// caller of acceptQueue.cond.Wait: every other access to readyList holds
+// q.mu just long enough to inspect or mutate it without blocking.
+func (e *endpoint) Accept() (*endpoint, *tcpip.Error) {
+	e.mu.Lock()
+	q := e.acceptQueue
+	e.mu.Unlock()
+
+	if q == nil {
+		return nil, tcpip.ErrInvalidEndpointState
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.readyList.Len() == 0 && !q.closed {
+		q.cond.Wait()
+	}
+
+	if q.readyList.Len() == 0 {
+		return nil, tcpip.ErrClosedForReceive
+	}
+
+	front := q.readyList.Front()
+	q.readyList.Remove(front)
+	return front.Value.(*endpoint), nil
+}
+
+// TransportEndpointStats returns the accept-queue counters backing this
+// listening endpoint: SYNs dropped for a full queue, handshake-completed
+// endpoints dropped for the same reason, SYN-cookies sent, and cookies
+// rejected. The underlying fields stay unexported, like the rest of
+// acceptQueue's bookkeeping, and are only ever read through this method.
+func (e *endpoint) TransportEndpointStats() (synRcvdDrops, acceptQueueDrops, cookiesSent, cookiesRejected uint64) {
+	e.mu.Lock()
+	q := e.acceptQueue
+	e.mu.Unlock()
+
+	if q == nil {
+		return 0, 0, 0, 0
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.synRcvdDrops, q.acceptQueueDrops, q.cookiesSent, q.cookiesRejected
+}
+
+// ListenStats returns the listening endpoint's handshake-failure counters
+// (see ListenStats in accept.go: HandshakeFailed, CookieMSSOutOfRange,
+// SegmentHandlingErrors). These come from the *listenContext that backs
+// protocolListenLoop, stored on the endpoint for exactly this purpose, and
+// are distinct from the load-shedding counters TransportEndpointStats
+// reports. It returns the zero value if the endpoint isn't listening.
+func (e *endpoint) ListenStats() ListenStats {
+	e.mu.Lock()
+	ctx := e.listenCtx
+	e.mu.Unlock()
+
+	if ctx == nil {
+		return ListenStats{}
+	}
+
+	ctx.statsMu.Lock()
+	defer ctx.statsMu.Unlock()
+	return ctx.stats
+}
@@ -0,0 +1,21 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tcp
+
+import "crypto/rand"
+
+// SecureRNG is the source of cryptographically secure random bytes used to
+// seed and rotate a listener's SYN-cookie nonces. This package reads it from
+// the package-level secureRNG var below, not from stack.Stack.
+type SecureRNG interface {
+	// Read fills p with random bytes and returns len(p), nil, or an error
+	// if it could not do so.
+	Read(p []byte) (int, error)
+}
+
+// secureRNG is the SecureRNG used to seed and rotate listenContext nonces. It
+// defaults to crypto/rand. It is an unexported var: code in this package,
+// such as its tests, can replace it, but other packages cannot.
+var secureRNG SecureRNG = rand.Reader
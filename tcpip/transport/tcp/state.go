@@ -0,0 +1,53 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tcp
+
+import "io"
+
+// EndpointState represents the state of a TCP endpoint as observed by the
+// checkpoint/restore subsystem. It mirrors the (unexported) states tracked
+// internally by endpoint.state, but is exported and stable across internal
+// refactors so that saved images remain loadable by newer binaries.
+//
+// Nothing in this package dispatches on EndpointState yet: listenContext,
+// the only current Saver, only ever saves/loads a listening endpoint, so it
+// has no state to switch on. A per-EndpointState handler is what a full
+// implementation covering StateConnecting/StateEstablished/etc. would need,
+// not something this package provides today.
+type EndpointState uint32
+
+// The states an endpoint can be saved and loaded from. They are ordered to
+// roughly follow the TCP state diagram.
+const (
+	StateInitial EndpointState = iota
+	StateBound
+	StateListen
+	StateConnecting
+	StateEstablished
+	StateFinWait1
+	StateFinWait2
+	StateTimeWait
+	StateCloseWait
+	StateLastAck
+	StateClosing
+	StateClosed
+)
+
+// Saver is implemented by values that support checkpoint/restore.
+// listenContext is the only implementation (see its Save/Load in accept.go),
+// and it covers listener metadata only: rcvWnd, v6only, netProto, the
+// SYN-cookie nonces, and the port pairs of segments mid-handshake. It does
+// not persist endpoint, sender, receiver or segment state, does not
+// re-register anything with the stack, and does not relaunch
+// protocolListenLoop or protocolMainLoop; resuming a connected endpoint or
+// an in-flight handshake needs all of that and is out of scope here.
+type Saver interface {
+	// Save writes the value's state to w.
+	Save(w io.Writer) error
+
+	// Load replaces the value's state with what was previously written
+	// by Save.
+	Load(r io.Reader) error
+}
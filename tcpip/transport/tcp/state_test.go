@@ -0,0 +1,75 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tcp
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"testing"
+
+	"github.com/google/netstack/tcpip/seqnum"
+	"github.com/google/netstack/tcpip/stack"
+)
+
+// TestListenContextSaveLoadState checks that Load reconstructs every field
+// Save writes, including recovering the pending segments' ports into
+// restoredPendingPorts per the contract documented on Load and
+// restoredPendingPort.
+func TestListenContextSaveLoadState(t *testing.T) {
+	src := &listenContext{
+		rcvWnd:   seqnum.Size(32768),
+		v6only:   true,
+		netProto: 2,
+		pendingSegs: map[*segment]struct{}{
+			{id: stack.TransportEndpointID{LocalPort: 1234, RemotePort: 80}}:   {},
+			{id: stack.TransportEndpointID{LocalPort: 5678, RemotePort: 443}}: {},
+		},
+	}
+	src.nonce[0] = [sha1.BlockSize]byte{1, 2, 3}
+	src.nonce[1] = [sha1.BlockSize]byte{4, 5, 6}
+
+	var buf bytes.Buffer
+	if err := src.Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	dst := &listenContext{}
+	if err := dst.Load(&buf); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if dst.rcvWnd != src.rcvWnd {
+		t.Errorf("rcvWnd = %d, want %d", dst.rcvWnd, src.rcvWnd)
+	}
+	if dst.v6only != src.v6only {
+		t.Errorf("v6only = %v, want %v", dst.v6only, src.v6only)
+	}
+	if dst.netProto != src.netProto {
+		t.Errorf("netProto = %d, want %d", dst.netProto, src.netProto)
+	}
+	if dst.nonce != src.nonce {
+		t.Errorf("nonce = %v, want %v", dst.nonce, src.nonce)
+	}
+
+	if got, want := len(dst.restoredPendingPorts), len(src.pendingSegs); got != want {
+		t.Fatalf("len(restoredPendingPorts) = %d, want %d", got, want)
+	}
+	seen := make(map[restoredPendingPort]bool)
+	for s := range src.pendingSegs {
+		seen[restoredPendingPort{LocalPort: s.id.LocalPort, RemotePort: s.id.RemotePort}] = false
+	}
+	for _, p := range dst.restoredPendingPorts {
+		if _, ok := seen[p]; !ok {
+			t.Errorf("unexpected restored port pair %+v", p)
+			continue
+		}
+		seen[p] = true
+	}
+	for p, ok := range seen {
+		if !ok {
+			t.Errorf("port pair %+v from pendingSegs missing from restoredPendingPorts", p)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2016 The Netstack Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tcp
+
+import "testing"
+
+// TestEncodeDecodeSynOpts round-trips encodeSynOpts/decodeSynOpts across the
+// mssTable, a range of window scales, and both SACK-permitted values, so that
+// it catches encode and decode getting out of sync.
+func TestEncodeDecodeSynOpts(t *testing.T) {
+	for _, mss := range mssTable {
+		for wscale := -1; wscale < wscaleMask; wscale++ {
+			for _, sackPermitted := range []bool{false, true} {
+				data := encodeSynOpts(mss, wscale, sackPermitted)
+				mssIndex, gotWscale, gotSACK := decodeSynOpts(data)
+
+				if want := int(encodeMSS(mss)); mssIndex != want {
+					t.Errorf("encodeSynOpts(%d, %d, %v): decoded mssIndex = %d, want %d", mss, wscale, sackPermitted, mssIndex, want)
+				}
+				if gotWscale != wscale {
+					t.Errorf("encodeSynOpts(%d, %d, %v): decoded wscale = %d, want %d", mss, wscale, sackPermitted, gotWscale, wscale)
+				}
+				if gotSACK != sackPermitted {
+					t.Errorf("encodeSynOpts(%d, %d, %v): decoded sackPermitted = %v, want %v", mss, wscale, sackPermitted, gotSACK, sackPermitted)
+				}
+			}
+		}
+	}
+}
+
+// TestEncodeSynOptsClampsWindowScale checks that a wscale at or above
+// wscaleMask (wscaleMask itself being the noWindowScale sentinel) gets
+// clamped to the largest representable real scale rather than silently
+// colliding with the "no window scale" encoding.
+func TestEncodeSynOptsClampsWindowScale(t *testing.T) {
+	data := encodeSynOpts(mssTable[0], wscaleMask, false)
+	_, wscale, _ := decodeSynOpts(data)
+	if want := wscaleMask - 1; wscale != want {
+		t.Errorf("decoded wscale = %d, want %d", wscale, want)
+	}
+}
+
+// TestEncodeSynOptsNoWindowScale checks that a wscale of -1 (the peer's SYN
+// carried no window scale option) survives the round trip as -1, rather than
+// being confused with a real scale of 0.
+func TestEncodeSynOptsNoWindowScale(t *testing.T) {
+	data := encodeSynOpts(mssTable[0], -1, true)
+	_, wscale, sackPermitted := decodeSynOpts(data)
+	if wscale != -1 {
+		t.Errorf("decoded wscale = %d, want -1", wscale)
+	}
+	if !sackPermitted {
+		t.Errorf("decoded sackPermitted = false, want true")
+	}
+}
+
+// TestDecodeSynOptsMSSIndexNeverOutOfRange documents why
+// handleListenSegment's "mssIndex >= len(mssTable)" guard can't be exercised
+// through encodeSynOpts/decodeSynOpts as they stand today: decodeSynOpts
+// masks the index to mssIndexBits, which leaves room for exactly
+// len(mssTable) values, so no cookie, whichever binary minted it, decodes to
+// an out-of-range index. If this ever fails, either mssTable or mssIndexBits
+// changed without the other, and the guard in handleListenSegment (and
+// CookieMSSOutOfRange's doc comment) need to be revisited.
+func TestDecodeSynOptsMSSIndexNeverOutOfRange(t *testing.T) {
+	if got, want := uint32(mssIndexMask), uint32(len(mssTable)-1); got != want {
+		t.Fatalf("mssIndexMask = %d, want %d (len(mssTable)-1)", got, want)
+	}
+}